@@ -0,0 +1,98 @@
+package cassandra
+
+import (
+	"archive/zip"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/gocql/gocql"
+)
+
+// astraBundleConfig mirrors the subset of config.json inside a DataStax
+// Astra secure connect bundle that the driver needs in order to reach the
+// cluster.
+type astraBundleConfig struct {
+	Host string `json:"host"`
+	Port int    `json:"port"`
+}
+
+// loadAstraBundle extracts the cluster endpoint and mutual TLS material
+// from an Astra secure connect bundle zip (config.json, cert, key, ca.crt),
+// matching how the DataStax drivers consume the same bundle.
+func loadAstraBundle(path string) ([]string, int, *gocql.SslOptions, error) {
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("opening secure connect bundle: %s", err)
+	}
+	defer r.Close()
+
+	var config astraBundleConfig
+	var certPEM, keyPEM, caPEM []byte
+
+	for _, f := range r.File {
+		var dst *[]byte
+		switch f.Name {
+		case "config.json":
+			data, err := readZipFile(f)
+			if err != nil {
+				return nil, 0, nil, err
+			}
+			if err := json.Unmarshal(data, &config); err != nil {
+				return nil, 0, nil, fmt.Errorf("parsing config.json: %s", err)
+			}
+			continue
+		case "cert":
+			dst = &certPEM
+		case "key":
+			dst = &keyPEM
+		case "ca.crt":
+			dst = &caPEM
+		default:
+			continue
+		}
+
+		if *dst, err = readZipFile(f); err != nil {
+			return nil, 0, nil, err
+		}
+	}
+
+	if config.Host == "" {
+		return nil, 0, nil, fmt.Errorf("secure connect bundle config.json is missing host")
+	}
+	if len(certPEM) == 0 || len(keyPEM) == 0 || len(caPEM) == 0 {
+		return nil, 0, nil, fmt.Errorf("secure connect bundle is missing cert, key or ca.crt")
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("parsing client certificate: %s", err)
+	}
+
+	caPool := x509.NewCertPool()
+	if !caPool.AppendCertsFromPEM(caPEM) {
+		return nil, 0, nil, fmt.Errorf("parsing ca.crt")
+	}
+
+	sslOpts := &gocql.SslOptions{
+		Config: &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			RootCAs:      caPool,
+			ServerName:   config.Host,
+		},
+		EnableHostVerification: true,
+	}
+
+	return []string{config.Host}, config.Port, sslOpts, nil
+}
+
+func readZipFile(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
+}
@@ -0,0 +1,147 @@
+package cassandra
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// selfSignedKeyPairPEM generates a throwaway self-signed certificate and
+// returns its cert and key in PEM form, usable as both the client
+// certificate and the CA for test purposes.
+func selfSignedKeyPairPEM(t *testing.T) (certPEM, keyPEM []byte) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating key: %s", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "astra-test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("creating certificate: %s", err)
+	}
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	return certPEM, keyPEM
+}
+
+// writeBundle builds a secure connect bundle zip at dir/bundle.zip from the
+// given file contents, omitting any entry whose content is nil, and
+// returns its path.
+func writeBundle(t *testing.T, dir string, files map[string][]byte) string {
+	t.Helper()
+
+	path := filepath.Join(dir, "bundle.zip")
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		if content == nil {
+			continue
+		}
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("creating zip entry %q: %s", name, err)
+		}
+		if _, err := w.Write(content); err != nil {
+			t.Fatalf("writing zip entry %q: %s", name, err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("closing zip writer: %s", err)
+	}
+
+	if err := os.WriteFile(path, buf.Bytes(), 0o600); err != nil {
+		t.Fatalf("writing bundle: %s", err)
+	}
+	return path
+}
+
+func TestLoadAstraBundle(t *testing.T) {
+	certPEM, keyPEM := selfSignedKeyPairPEM(t)
+	configJSON := []byte(`{"host":"db.example.com","port":29042}`)
+
+	dir := t.TempDir()
+	path := writeBundle(t, dir, map[string][]byte{
+		"config.json": configJSON,
+		"cert":        certPEM,
+		"key":         keyPEM,
+		"ca.crt":      certPEM,
+	})
+
+	hosts, port, sslOpts, err := loadAstraBundle(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(hosts) != 1 || hosts[0] != "db.example.com" {
+		t.Errorf("hosts = %v, want [db.example.com]", hosts)
+	}
+	if port != 29042 {
+		t.Errorf("port = %d, want 29042", port)
+	}
+	if sslOpts == nil || sslOpts.Config == nil {
+		t.Fatal("expected ssl options with a tls.Config to be populated")
+	}
+	if len(sslOpts.Config.Certificates) != 1 {
+		t.Errorf("expected exactly one client certificate, got %d", len(sslOpts.Config.Certificates))
+	}
+	if sslOpts.Config.RootCAs == nil {
+		t.Error("expected a CA pool to be populated")
+	}
+}
+
+func TestLoadAstraBundleMissingCertMaterial(t *testing.T) {
+	configJSON := []byte(`{"host":"db.example.com","port":29042}`)
+
+	dir := t.TempDir()
+	path := writeBundle(t, dir, map[string][]byte{
+		"config.json": configJSON,
+	})
+
+	if _, _, _, err := loadAstraBundle(path); err == nil {
+		t.Fatal("expected an error for a bundle missing cert, key and ca.crt")
+	}
+}
+
+func TestLoadAstraBundleMissingHost(t *testing.T) {
+	certPEM, keyPEM := selfSignedKeyPairPEM(t)
+	configJSON := []byte(`{"port":29042}`)
+
+	dir := t.TempDir()
+	path := writeBundle(t, dir, map[string][]byte{
+		"config.json": configJSON,
+		"cert":        certPEM,
+		"key":         keyPEM,
+		"ca.crt":      certPEM,
+	})
+
+	if _, _, _, err := loadAstraBundle(path); err == nil {
+		t.Fatal("expected an error for a bundle whose config.json has no host")
+	}
+}
+
+func TestLoadAstraBundleNotFound(t *testing.T) {
+	if _, _, _, err := loadAstraBundle(filepath.Join(t.TempDir(), "missing.zip")); err == nil {
+		t.Fatal("expected an error opening a bundle that does not exist")
+	}
+}
@@ -2,7 +2,9 @@
 package cassandra
 
 import (
+	"bytes"
 	"fmt"
+	"hash/fnv"
 	"net/url"
 	"sort"
 	"strconv"
@@ -16,16 +18,58 @@ import (
 )
 
 type Driver struct {
-	session *gocql.Session
+	session         *gocql.Session
+	externalSession bool
+
+	migrationsTable string
+	consistency     *gocql.Consistency
+
+	lockTable string
+	lockTTL   time.Duration
+	lockID    string
+	lockOwner string
+
+	multiStatementEnabled bool
+	multiStatementMaxSize int
+}
+
+// Config holds the options needed to build a Driver around a *gocql.Session
+// the caller already created, as an alternative to Open. It lets callers
+// keep full control over the session (retry policies, host selection
+// policy, tracing, custom authenticators, ...) instead of encoding every
+// cluster option into a URL.
+type Config struct {
+	KeyspaceName          string
+	MigrationsTable       string
+	MultiStatementEnabled bool
+	MultiStatementMaxSize int
+	// ConsistencyLevel overrides the session's consistency for this
+	// driver's queries when non-nil. A nil pointer leaves the session's
+	// own default in place; this is distinct from a zero value, since
+	// gocql.Any is itself the zero gocql.Consistency.
+	ConsistencyLevel *gocql.Consistency
 }
 
 // make sure our driver still implements the driver.Driver interface
 var _ driver.Driver = (*Driver)(nil)
 
 const (
-	tableName = "schema_migrations"
+	tableName        = "schema_migrations"
+	defaultLockTable = "schema_migrations_lock"
+	defaultLockTTL   = 15 * time.Minute
 )
 
+// ErrDatabaseLocked is returned by Lock when another process already
+// holds the migration lock.
+var ErrDatabaseLocked = fmt.Errorf("database is locked")
+
+// ErrDatabaseDirty is returned by Open, Version and Migrate when the
+// version table carries a dirty row, meaning a previous migration failed
+// partway through and the schema is in an unknown state. The operator must
+// repair the schema by hand and call Force to clear the flag before
+// migrating again.
+var ErrDatabaseDirty = fmt.Errorf("database is dirty")
+
 // Cassandra Driver URL format:
 // cassandra://host:port/keyspace?protocol=version&consistency=level
 //
@@ -33,19 +77,34 @@ const (
 // cassandra://localhost/SpaceOfKeys?protocol=4
 // cassandra://localhost/SpaceOfKeys?protocol=4&consistency=all
 // cassandra://localhost/SpaceOfKeys?consistency=quorum
+// cassandra://localhost/SpaceOfKeys?x-migrations-lock-table=my_lock&x-migrations-lock-ttl=900
+// cassandra://localhost/SpaceOfKeys?x-multi-statement=true&x-multi-statement-max-size=20971520
+// cassandra://h1,h2,h3/SpaceOfKeys?port=9042&datacenter=dc1&token_aware=true
+// cassandra://astra/SpaceOfKeys?auth=astra&secure_connect_bundle=/path/to/bundle.zip
+// cassandra://cassandra.us-east-1.amazonaws.com:9142/SpaceOfKeys?auth=sigv4&aws_region=us-east-1
 func Open(rawurl string) (driver.Driver, error) {
-	driver := &Driver{}
+	driver := &Driver{migrationsTable: tableName}
 	u, err := url.Parse(rawurl)
 
-	cluster := gocql.NewCluster(u.Host)
+	cluster := gocql.NewCluster(strings.Split(u.Host, ",")...)
 	cluster.Keyspace = u.Path[1:len(u.Path)]
 	cluster.Consistency = gocql.All
 	cluster.Timeout = 1 * time.Minute
 
 	if consistencyStr := u.Query().Get("consistency"); len(consistencyStr) > 0 {
-		// Warning: gocql.ParseConsistency will PANIC if there's an error.
-		// See https://github.com/gocql/gocql/commit/f52d33ca51e4216a6bf6af74f80e023e69700afd
-		cluster.Consistency = gocql.ParseConsistency(consistencyStr)
+		consistency, err := parseConsistency(consistencyStr)
+		if err != nil {
+			return nil, err
+		}
+		cluster.Consistency = consistency
+	}
+
+	if serialConsistencyStr := u.Query().Get("serial_consistency"); len(serialConsistencyStr) > 0 {
+		serialConsistency, err := parseSerialConsistency(serialConsistencyStr)
+		if err != nil {
+			return nil, err
+		}
+		cluster.SerialConsistency = serialConsistency
 	}
 
 	if len(u.Query().Get("protocol")) > 0 {
@@ -53,28 +112,161 @@ func Open(rawurl string) (driver.Driver, error) {
 		if err != nil {
 			return nil, err
 		}
+		if protoversion < 3 || protoversion > 5 {
+			return nil, fmt.Errorf("unsupported protocol version %d, must be one of 3, 4, 5", protoversion)
+		}
 
 		cluster.ProtoVersion = protoversion
 	}
 
+	if portStr := u.Query().Get("port"); len(portStr) > 0 {
+		port, err := strconv.Atoi(portStr)
+		if err != nil {
+			return nil, err
+		}
+		cluster.Port = port
+	}
+
+	if timeoutStr := u.Query().Get("timeout"); len(timeoutStr) > 0 {
+		timeoutSeconds, err := strconv.Atoi(timeoutStr)
+		if err != nil {
+			return nil, err
+		}
+		cluster.Timeout = time.Duration(timeoutSeconds) * time.Second
+	}
+
+	if connectTimeoutStr := u.Query().Get("connect_timeout"); len(connectTimeoutStr) > 0 {
+		connectTimeoutSeconds, err := strconv.Atoi(connectTimeoutStr)
+		if err != nil {
+			return nil, err
+		}
+		cluster.ConnectTimeout = time.Duration(connectTimeoutSeconds) * time.Second
+	}
+
+	if numConnsStr := u.Query().Get("num_conns"); len(numConnsStr) > 0 {
+		numConns, err := strconv.Atoi(numConnsStr)
+		if err != nil {
+			return nil, err
+		}
+		cluster.NumConns = numConns
+	}
+
+	tokenAware := false
+	if tokenAwareStr := u.Query().Get("token_aware"); len(tokenAwareStr) > 0 {
+		tokenAware, err = strconv.ParseBool(tokenAwareStr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if datacenter := u.Query().Get("datacenter"); len(datacenter) > 0 {
+		var policy gocql.HostSelectionPolicy = gocql.DCAwareRoundRobinPolicy(datacenter)
+		if tokenAware {
+			policy = gocql.TokenAwareHostPolicy(policy)
+		}
+		cluster.PoolConfig.HostSelectionPolicy = policy
+		// DCAwareRoundRobinPolicy only biases routing preference; without a
+		// HostFilter the driver still connects to every discovered host in
+		// every datacenter, which is the actual cost/firewall concern this
+		// option exists for.
+		cluster.HostFilter = gocql.DataCentreHostFilter(datacenter)
+	} else if tokenAware {
+		cluster.PoolConfig.HostSelectionPolicy = gocql.TokenAwareHostPolicy(gocql.RoundRobinHostPolicy())
+	}
+
 	if _, ok := u.Query()["disable_init_host_lookup"]; ok {
 		cluster.DisableInitialHostLookup = true
 	}
 
-	// Check if url user struct is null
-	if u.User != nil {
-		password, passwordSet := u.User.Password()
+	driver.lockTable = defaultLockTable
+	if lockTable := u.Query().Get("x-migrations-lock-table"); len(lockTable) > 0 {
+		driver.lockTable = lockTable
+	}
 
-		if passwordSet == false {
-			return nil, fmt.Errorf("Missing password. Please provide password.")
+	driver.lockTTL = defaultLockTTL
+	if lockTTLStr := u.Query().Get("x-migrations-lock-ttl"); len(lockTTLStr) > 0 {
+		lockTTLSeconds, err := strconv.Atoi(lockTTLStr)
+		if err != nil {
+			return nil, err
 		}
+		driver.lockTTL = time.Duration(lockTTLSeconds) * time.Second
+	}
+	driver.lockID = lockIDFromKeyspace(cluster.Keyspace)
 
-		cluster.Authenticator = gocql.PasswordAuthenticator{
-			Username: u.User.Username(),
-			Password: password,
+	if _, ok := u.Query()["x-multi-statement"]; ok {
+		multiStatement, err := strconv.ParseBool(u.Query().Get("x-multi-statement"))
+		if err != nil {
+			return nil, err
+		}
+		driver.multiStatementEnabled = multiStatement
+	}
+
+	driver.multiStatementMaxSize = DefaultMultiStatementMaxSize
+	if maxSizeStr := u.Query().Get("x-multi-statement-max-size"); len(maxSizeStr) > 0 {
+		maxSize, err := strconv.Atoi(maxSizeStr)
+		if err != nil {
+			return nil, err
 		}
+		driver.multiStatementMaxSize = maxSize
+	}
 
+	authMethod := u.Query().Get("auth")
+	if authMethod == "" {
+		authMethod = "password"
 	}
+
+	switch authMethod {
+	case "password":
+		// Check if url user struct is null
+		if u.User != nil {
+			password, passwordSet := u.User.Password()
+
+			if passwordSet == false {
+				return nil, fmt.Errorf("Missing password. Please provide password.")
+			}
+
+			cluster.Authenticator = gocql.PasswordAuthenticator{
+				Username: u.User.Username(),
+				Password: password,
+			}
+		}
+	case "astra":
+		bundle := u.Query().Get("secure_connect_bundle")
+		if bundle == "" {
+			return nil, fmt.Errorf("auth=astra requires secure_connect_bundle")
+		}
+
+		hosts, port, sslOpts, err := loadAstraBundle(bundle)
+		if err != nil {
+			return nil, err
+		}
+		cluster.Hosts = hosts
+		cluster.Port = port
+		cluster.SslOpts = sslOpts
+
+		if u.User != nil {
+			password, _ := u.User.Password()
+			cluster.Authenticator = gocql.PasswordAuthenticator{
+				Username: u.User.Username(),
+				Password: password,
+			}
+		}
+	case "sigv4":
+		auth, err := newSigV4Authenticator(u.Query().Get("aws_region"), u.Query().Get("aws_profile"))
+		if err != nil {
+			return nil, err
+		}
+		cluster.Authenticator = auth
+	case "gssapi":
+		auth, err := newGSSAPIAuthenticator(u.Query())
+		if err != nil {
+			return nil, err
+		}
+		cluster.Authenticator = auth
+	default:
+		return nil, fmt.Errorf("unsupported auth method %q", authMethod)
+	}
+
 	// handle ssl option
 	if sslmode := u.Query().Get("sslmode"); sslmode != "" && sslmode != "disable" {
 		cluster.SslOpts = &gocql.SslOptions{
@@ -94,58 +286,263 @@ func Open(rawurl string) (driver.Driver, error) {
 		return nil, err
 	}
 
+	if err := driver.ensureLockTableExists(); err != nil {
+		return nil, err
+	}
+
+	if dirty, err := driver.isDirty(); err != nil {
+		return nil, err
+	} else if dirty {
+		return nil, ErrDatabaseDirty
+	}
+
 	return driver, nil
 }
 
+// parseConsistency is a panic-safe wrapper around gocql.ParseConsistency,
+// which panics on an unrecognized level.
+// See https://github.com/gocql/gocql/commit/f52d33ca51e4216a6bf6af74f80e023e69700afd
+func parseConsistency(name string) (c gocql.Consistency, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("invalid consistency level %q", name)
+		}
+	}()
+	c = gocql.ParseConsistency(name)
+	return
+}
+
+// parseSerialConsistency parses the serial_consistency URL parameter.
+func parseSerialConsistency(name string) (gocql.SerialConsistency, error) {
+	switch strings.ToLower(name) {
+	case "serial":
+		return gocql.Serial, nil
+	case "local_serial", "localserial":
+		return gocql.LocalSerial, nil
+	default:
+		return 0, fmt.Errorf("invalid serial consistency level %q", name)
+	}
+}
+
+// lockIDFromKeyspace derives a stable lock identifier from the keyspace
+// name so that independent migrators targeting the same keyspace contend
+// for the same lock row.
+func lockIDFromKeyspace(keyspace string) string {
+	h := fnv.New64a()
+	h.Write([]byte(keyspace))
+	return strconv.FormatUint(h.Sum64(), 10)
+}
+
+// query builds a gocql.Query for stmt, applying the driver's consistency
+// level override when one was supplied via Config.
+func (driver *Driver) query(stmt string, args ...interface{}) *gocql.Query {
+	query := driver.session.Query(stmt, args...)
+	if driver.consistency != nil {
+		query = query.Consistency(*driver.consistency)
+	}
+	return query
+}
+
 func (driver *Driver) Close() error {
-	driver.session.Close()
+	if !driver.externalSession {
+		driver.session.Close()
+	}
 	return nil
 }
 
+// WithInstance creates a Driver from a *gocql.Session the caller already
+// built and configured (custom retry policy, host selection policy, token
+// awareness, tracing, a sigv4/Kerberos Authenticator, ...), letting the
+// rest of the application share that session instead of the driver
+// creating its own from a URL. Close will not close a session supplied
+// this way; the caller remains responsible for it.
+func WithInstance(session *gocql.Session, config *Config) (driver.Driver, error) {
+	if config == nil {
+		return nil, fmt.Errorf("no config provided")
+	}
+	if session == nil || session.Closed() {
+		return nil, fmt.Errorf("session is closed")
+	}
+	if config.KeyspaceName == "" {
+		return nil, fmt.Errorf("no keyspace provided")
+	}
+
+	d := &Driver{
+		session:         session,
+		externalSession: true,
+
+		migrationsTable: tableName,
+
+		lockTable: defaultLockTable,
+		lockTTL:   defaultLockTTL,
+		lockID:    lockIDFromKeyspace(config.KeyspaceName),
+
+		multiStatementEnabled: config.MultiStatementEnabled,
+		multiStatementMaxSize: DefaultMultiStatementMaxSize,
+	}
+
+	if config.MigrationsTable != "" {
+		d.migrationsTable = config.MigrationsTable
+	}
+	if config.MultiStatementMaxSize > 0 {
+		d.multiStatementMaxSize = config.MultiStatementMaxSize
+	}
+	d.consistency = config.ConsistencyLevel
+
+	if err := d.ensureVersionTableExists(); err != nil {
+		return nil, err
+	}
+
+	if err := d.ensureLockTableExists(); err != nil {
+		return nil, err
+	}
+
+	if dirty, err := d.isDirty(); err != nil {
+		return nil, err
+	} else if dirty {
+		return nil, ErrDatabaseDirty
+	}
+
+	return d, nil
+}
+
 func (driver *Driver) ensureVersionTableExists() error {
-	err := driver.session.Query("CREATE TABLE IF NOT EXISTS " + tableName + " (version bigint primary key);").Exec()
+	err := driver.query("CREATE TABLE IF NOT EXISTS " + driver.migrationsTable + " (version bigint primary key, dirty boolean);").Exec()
 	return err
 }
 
-func (driver *Driver) Migrate(f file.File) (err error) {
-	defer func() {
-		if err != nil {
-			// Invert version direction if we couldn't apply the changes for some reason.
-			if errRollback := driver.session.Query("DELETE FROM "+tableName+" WHERE version = ?", f.Version).Exec(); errRollback != nil {
-				err = fmt.Errorf("%s; failed to rollback version: %s", err, errRollback)
-			}
-		}
-	}()
+// isDirty reports whether the version table has a row marked dirty, i.e. a
+// previous migration was interrupted partway through.
+func (driver *Driver) isDirty() (bool, error) {
+	iter := driver.query("SELECT version FROM " + driver.migrationsTable + " WHERE dirty = true LIMIT 1 ALLOW FILTERING").Iter()
+	var version int64
+	dirty := iter.Scan(&version)
+	err := iter.Close()
+	return dirty, err
+}
+
+// Force sets the given version and clears its dirty flag, letting an
+// operator resume migrating after manually repairing a schema that was
+// left dirty by a failed migration.
+func (driver *Driver) Force(version int64) error {
+	return driver.query("INSERT INTO "+driver.migrationsTable+" (version, dirty) VALUES (?, false)", version).Exec()
+}
+
+func (driver *Driver) ensureLockTableExists() error {
+	err := driver.query("CREATE TABLE IF NOT EXISTS " + driver.lockTable + " (lock_id text primary key, owner text);").Exec()
+	return err
+}
+
+// Lock acquires the distributed migration lock using a lightweight
+// transaction, so that only one migrator can run against a given keyspace
+// at a time. The lock row carries a TTL so that a crashed migrator
+// eventually releases it instead of wedging the keyspace forever. Each
+// acquisition is stamped with a fresh random owner token so that Unlock
+// only ever releases the lock this Driver actually holds, not one a
+// different process re-acquired after this one's TTL expired.
+func (driver *Driver) Lock() error {
+	owner, err := gocql.RandomUUID()
+	if err != nil {
+		return err
+	}
+
+	applied, err := driver.query(
+		"INSERT INTO "+driver.lockTable+" (lock_id, owner) VALUES (?, ?) IF NOT EXISTS USING TTL ?",
+		driver.lockID, owner.String(), int(driver.lockTTL/time.Second),
+	).MapScanCAS(make(map[string]interface{}))
+	if err != nil {
+		return err
+	}
+	if !applied {
+		return ErrDatabaseLocked
+	}
+	driver.lockOwner = owner.String()
+	return nil
+}
 
+// Unlock releases the distributed migration lock acquired by Lock, using
+// the owner token stamped at acquisition time so a stale Unlock can never
+// release a lock a different process has since acquired.
+func (driver *Driver) Unlock() error {
+	err := driver.query(
+		"DELETE FROM "+driver.lockTable+" WHERE lock_id = ? IF owner = ?",
+		driver.lockID, driver.lockOwner,
+	).Exec()
+	if err != nil {
+		return err
+	}
+	driver.lockOwner = ""
+	return nil
+}
+
+// Migrate applies a single migration file. Locking is handled by the
+// migrate package itself: it detects that Driver implements
+// driver.Lockable and wraps the whole batch of files in a single
+// Lock/Unlock around its calls to Migrate, so Migrate must not acquire
+// the lock again here.
+func (driver *Driver) Migrate(f file.File) (err error) {
 	if err = f.ReadContent(); err != nil {
 		return
 	}
 
-	if f.Direction == direction.Up {
-		if err = driver.session.Query("INSERT INTO "+tableName+" (version) VALUES (?)", f.Version).Exec(); err != nil {
+	if dirty, dirtyErr := driver.isDirty(); dirtyErr != nil {
+		err = dirtyErr
+		return
+	} else if dirty {
+		err = ErrDatabaseDirty
+		return
+	}
+
+	// Mark the version dirty before touching the schema. If anything below
+	// fails, the row is left dirty so Open/Version refuse to proceed until
+	// an operator repairs the schema and calls Force.
+	if err = driver.query("INSERT INTO "+driver.migrationsTable+" (version, dirty) VALUES (?, true)", f.Version).Exec(); err != nil {
+		return
+	}
+
+	if driver.multiStatementEnabled {
+		var execErr error
+		if err = parseMultiStatements(bytes.NewReader(f.Content), driver.multiStatementMaxSize, func(stmt []byte) bool {
+			if execErr = driver.query(string(stmt)).Exec(); execErr != nil {
+				return false
+			}
+			return true
+		}); err != nil {
 			return
 		}
-	} else if f.Direction == direction.Down {
-		if err = driver.session.Query("DELETE FROM "+tableName+" WHERE version = ?", f.Version).Exec(); err != nil {
+		if execErr != nil {
+			err = execErr
 			return
 		}
-	}
+	} else {
+		for _, query := range strings.Split(string(f.Content), ";") {
+			query = strings.TrimSpace(query)
+			if len(query) == 0 {
+				continue
+			}
 
-	for _, query := range strings.Split(string(f.Content), ";") {
-		query = strings.TrimSpace(query)
-		if len(query) == 0 {
-			continue
+			if err = driver.query(query).Exec(); err != nil {
+				return
+			}
 		}
+	}
 
-		if err = driver.session.Query(query).Exec(); err != nil {
-			return
-		}
+	if f.Direction == direction.Up {
+		err = driver.query("UPDATE "+driver.migrationsTable+" SET dirty = false WHERE version = ?", f.Version).Exec()
+	} else if f.Direction == direction.Down {
+		err = driver.query("DELETE FROM "+driver.migrationsTable+" WHERE version = ?", f.Version).Exec()
 	}
 	return
 }
 
 // Version returns the current migration version.
 func (driver *Driver) Version() (file.Version, error) {
+	if dirty, err := driver.isDirty(); err != nil {
+		return 0, err
+	} else if dirty {
+		return 0, ErrDatabaseDirty
+	}
+
 	versions, err := driver.Versions()
 	if len(versions) == 0 {
 		return 0, err
@@ -156,7 +553,7 @@ func (driver *Driver) Version() (file.Version, error) {
 // Versions returns the list of applied migrations.
 func (driver *Driver) Versions() (file.Versions, error) {
 	versions := file.Versions{}
-	iter := driver.session.Query("SELECT version FROM " + tableName).Iter()
+	iter := driver.query("SELECT version FROM " + driver.migrationsTable).Iter()
 	var version int64
 	for iter.Scan(&version) {
 		versions = append(versions, file.Version(version))
@@ -168,7 +565,7 @@ func (driver *Driver) Versions() (file.Versions, error) {
 
 // Execute a SQL statement
 func (driver *Driver) Execute(statement string) error {
-	return driver.session.Query(statement).Exec()
+	return driver.query(statement).Exec()
 }
 
 func init() {
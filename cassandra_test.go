@@ -0,0 +1,72 @@
+package cassandra
+
+import (
+	"testing"
+
+	"github.com/gocql/gocql"
+)
+
+func TestParseConsistency(t *testing.T) {
+	tests := []struct {
+		name    string
+		level   string
+		want    gocql.Consistency
+		wantErr bool
+	}{
+		{name: "quorum", level: "quorum", want: gocql.Quorum},
+		{name: "all", level: "all", want: gocql.All},
+		{name: "case insensitive", level: "QUORUM", want: gocql.Quorum},
+		{name: "unknown level", level: "not-a-level", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseConsistency(tt.level)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error for %q, got none", tt.level)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if got != tt.want {
+				t.Errorf("parseConsistency(%q) = %v, want %v", tt.level, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSerialConsistency(t *testing.T) {
+	tests := []struct {
+		name    string
+		level   string
+		want    gocql.SerialConsistency
+		wantErr bool
+	}{
+		{name: "serial", level: "serial", want: gocql.Serial},
+		{name: "local_serial", level: "local_serial", want: gocql.LocalSerial},
+		{name: "localserial alias", level: "localserial", want: gocql.LocalSerial},
+		{name: "case insensitive", level: "SERIAL", want: gocql.Serial},
+		{name: "unknown level", level: "quorum", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSerialConsistency(tt.level)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error for %q, got none", tt.level)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if got != tt.want {
+				t.Errorf("parseSerialConsistency(%q) = %v, want %v", tt.level, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package cassandra
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// DefaultMultiStatementMaxSize is the upper bound on the size of a single
+// statement when x-multi-statement-max-size is not given.
+const DefaultMultiStatementMaxSize = 10 * 1024 * 1024 // 10 MiB
+
+// multiStatementHandler is called once for each statement parsed out of a
+// migration file. Returning false stops parsing early.
+type multiStatementHandler func(statement []byte) bool
+
+// parseMultiStatements streams r and splits it into statements delimited by
+// ';', honoring single-quoted string literals and '$$'-quoted bodies (as
+// used by CQL UDF/UDA definitions) so that semicolons inside either are not
+// mistaken for statement delimiters. Each statement is handed to handle as
+// soon as it is found, rather than buffering the whole file. A statement
+// larger than maxSize causes an error.
+func parseMultiStatements(r io.Reader, maxSize int, handle multiStatementHandler) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 4096), maxSize)
+	scanner.Split(splitMultiStatements(maxSize))
+
+	for scanner.Scan() {
+		stmt := bytes.TrimSpace(scanner.Bytes())
+		if len(stmt) == 0 {
+			continue
+		}
+		if !handle(stmt) {
+			break
+		}
+	}
+	return scanner.Err()
+}
+
+// splitMultiStatements returns a bufio.SplitFunc that tokenizes on ';',
+// tracking whether the scan position is inside a '...' string literal or a
+// $$...$$ quoted body so that delimiters there are ignored.
+func splitMultiStatements(maxSize int) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (advance int, token []byte, err error) {
+		var inString, inDollar bool
+
+		for i := 0; i < len(data); i++ {
+			switch data[i] {
+			case '\'':
+				if !inDollar {
+					inString = !inString
+				}
+			case '$':
+				if !inString && i+1 < len(data) && data[i+1] == '$' {
+					inDollar = !inDollar
+					i++
+				}
+			case ';':
+				if !inString && !inDollar {
+					if i > maxSize {
+						return 0, nil, fmt.Errorf("statement exceeds x-multi-statement-max-size of %d bytes", maxSize)
+					}
+					return i + 1, data[0:i], nil
+				}
+			}
+		}
+
+		if len(data) > maxSize {
+			return 0, nil, fmt.Errorf("statement exceeds x-multi-statement-max-size of %d bytes", maxSize)
+		}
+
+		if atEOF {
+			if len(data) == 0 {
+				return 0, nil, nil
+			}
+			return len(data), data, nil
+		}
+
+		return 0, nil, nil
+	}
+}
@@ -0,0 +1,98 @@
+package cassandra
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseMultiStatements(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		maxSize int
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:  "simple statements",
+			input: "CREATE TABLE a (id int primary key); INSERT INTO a (id) VALUES (1);",
+			want:  []string{"CREATE TABLE a (id int primary key)", "INSERT INTO a (id) VALUES (1)"},
+		},
+		{
+			name:  "no trailing semicolon",
+			input: "SELECT * FROM a; SELECT * FROM b",
+			want:  []string{"SELECT * FROM a", "SELECT * FROM b"},
+		},
+		{
+			name:  "semicolon inside string literal is not a delimiter",
+			input: "INSERT INTO a (s) VALUES ('a;b'); SELECT * FROM a;",
+			want:  []string{"INSERT INTO a (s) VALUES ('a;b')", "SELECT * FROM a"},
+		},
+		{
+			name:  "semicolon inside dollar-quoted UDF body is not a delimiter",
+			input: "CREATE FUNCTION f (x int) RETURNS int LANGUAGE java AS $$ return x; $$; SELECT 1;",
+			want: []string{
+				"CREATE FUNCTION f (x int) RETURNS int LANGUAGE java AS $$ return x; $$",
+				"SELECT 1",
+			},
+		},
+		{
+			name:  "blank statements are skipped",
+			input: ";;  ; SELECT 1;",
+			want:  []string{"SELECT 1"},
+		},
+		{
+			name:    "statement exceeding max size with no delimiter in sight is an error",
+			input:   strings.Repeat("a", 5000) + ";",
+			maxSize: 10,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			maxSize := tt.maxSize
+			if maxSize == 0 {
+				maxSize = DefaultMultiStatementMaxSize
+			}
+
+			var got []string
+			err := parseMultiStatements(strings.NewReader(tt.input), maxSize, func(stmt []byte) bool {
+				got = append(got, string(stmt))
+				return true
+			})
+
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d statements %q, want %d %q", len(got), got, len(tt.want), tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("statement %d = %q, want %q", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseMultiStatementsStopsOnHandlerFalse(t *testing.T) {
+	var got []string
+	err := parseMultiStatements(strings.NewReader("SELECT 1; SELECT 2; SELECT 3;"), DefaultMultiStatementMaxSize, func(stmt []byte) bool {
+		got = append(got, string(stmt))
+		return len(got) < 1
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("expected parsing to stop after the first statement, got %d: %q", len(got), got)
+	}
+}
@@ -0,0 +1,46 @@
+package cassandra
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sigv4-auth-cassandra-gocql-driver-plugin/sigv4"
+	"github.com/gocql/gocql"
+)
+
+// newSigV4Authenticator builds a gocql.Authenticator that signs the
+// connection's auth challenge with SigV4, as required by Amazon Keyspaces.
+// Credentials come from the given profile if one is set, otherwise from
+// the standard AWS SDK credential chain (env vars, shared config, EC2/ECS
+// instance role, ...).
+func newSigV4Authenticator(region, profile string) (gocql.Authenticator, error) {
+	if region == "" {
+		return nil, fmt.Errorf("auth=sigv4 requires aws_region")
+	}
+
+	if profile == "" {
+		auth := sigv4.NewAwsAuthenticatorWithRegion(region)
+		return auth, nil
+	}
+
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Profile:           profile,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("loading aws profile %q: %s", profile, err)
+	}
+
+	auth := sigv4.NewAwsAuthenticatorWithCredentialCallback(region, func() (sigv4.SigV4Credentials, error) {
+		creds, err := sess.Config.Credentials.Get()
+		if err != nil {
+			return sigv4.SigV4Credentials{}, err
+		}
+		return sigv4.SigV4Credentials{
+			AccessKeyId:     creds.AccessKeyID,
+			SecretAccessKey: creds.SecretAccessKey,
+			SessionToken:    creds.SessionToken,
+		}, nil
+	})
+	return auth, nil
+}